@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// statusBuffer holds non-channel numerics and NOTICE traffic.
+const statusBuffer = "*status*"
+
+// bufferSet tracks per-buffer scrollback, which buffer is active, and
+// which inactive buffers have unseen mentions.
+type bufferSet struct {
+	mu        sync.Mutex
+	names     []string
+	active    int
+	lines     map[string][]string
+	mentioned map[string]bool
+}
+
+func newBufferSet(channels []string) *bufferSet {
+	return &bufferSet{
+		names:     append([]string{statusBuffer}, channels...),
+		lines:     make(map[string][]string),
+		mentioned: make(map[string]bool),
+	}
+}
+
+func (b *bufferSet) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.names[b.active]
+}
+
+func (b *bufferSet) has(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, n := range b.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *bufferSet) append(name, line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[name] = append(b.lines[name], line)
+}
+
+func (b *bufferSet) render(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return strings.Join(b.lines[name], "\n")
+}
+
+// switchTo moves the active buffer by delta (wrapping) and returns its name.
+func (b *bufferSet) switchTo(delta int) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.names)
+	b.active = ((b.active+delta)%n + n) % n
+	name := b.names[b.active]
+	delete(b.mentioned, name)
+
+	return name
+}
+
+// markMentioned badges name as having an unseen mention, unless it's
+// already the active buffer. It reports whether the badge is new, so
+// callers know when a redraw is actually needed.
+func (b *bufferSet) markMentioned(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if name == b.names[b.active] || b.mentioned[name] {
+		return false
+	}
+
+	b.mentioned[name] = true
+
+	return true
+}
+
+// add registers name as a buffer (if it isn't already known) and
+// switches to it, so /join <channel> is immediately usable from the UI.
+func (b *bufferSet) add(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, n := range b.names {
+		if n == name {
+			b.active = i
+			delete(b.mentioned, name)
+
+			return
+		}
+	}
+
+	b.names = append(b.names, name)
+	b.active = len(b.names) - 1
+}
+
+// remove drops name (the status buffer is never removable). If name was
+// the active buffer, the status buffer becomes active; otherwise the
+// active buffer stays as it was.
+func (b *bufferSet) remove(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if name == statusBuffer {
+		return
+	}
+
+	idx := -1
+	for i, n := range b.names {
+		if n == name {
+			idx = i
+			break
+		}
+	}
+
+	if idx == -1 {
+		return
+	}
+
+	current := b.names[b.active]
+	b.names = append(b.names[:idx], b.names[idx+1:]...)
+	delete(b.lines, name)
+	delete(b.mentioned, name)
+
+	b.active = 0
+	if current != name {
+		for i, n := range b.names {
+			if n == current {
+				b.active = i
+				break
+			}
+		}
+	}
+}
+
+// label returns the prompt text for the channel view: the active
+// buffer's name, annotated with any other buffers that have unseen
+// mentions.
+func (b *bufferSet) label() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := b.names[b.active]
+
+	var badges []string
+	for _, n := range b.names {
+		if b.mentioned[n] {
+			badges = append(badges, n)
+		}
+	}
+
+	if len(badges) == 0 {
+		return name
+	}
+
+	return fmt.Sprintf("%s [%s]", name, strings.Join(badges, ","))
+}
@@ -0,0 +1,178 @@
+// Package capability implements IRCv3 capability negotiation (CAP
+// LS/REQ/ACK) and lets individual capabilities annotate how incoming
+// events should be interpreted.
+package capability
+
+import (
+	"strings"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+// Capability is a single negotiable IRCv3 capability.
+type Capability interface {
+	// Name is the token sent in CAP REQ, e.g. "server-time".
+	Name() string
+
+	// OnRegister runs once after the capability has been acknowledged
+	// and registration (CAP END) has completed.
+	OnRegister(c *irc.Connection)
+
+	// WrapEvent lets the capability annotate a Message built from an
+	// incoming event, e.g. overriding its display time.
+	WrapEvent(msg *Message)
+}
+
+// Message is event data enriched by whichever capabilities are enabled.
+type Message struct {
+	Event *irc.Event
+	Time  time.Time
+}
+
+// Set is the capabilities a server has acknowledged for this connection.
+type Set struct {
+	enabled map[string]Capability
+}
+
+func newSet() *Set {
+	return &Set{enabled: make(map[string]Capability)}
+}
+
+// Enabled reports whether the named capability was acknowledged.
+func (s *Set) Enabled(name string) bool {
+	_, ok := s.enabled[name]
+	return ok
+}
+
+// Wrap builds a Message from event, letting every enabled capability
+// annotate it in turn.
+func (s *Set) Wrap(event *irc.Event) *Message {
+	msg := &Message{Event: event, Time: time.Now()}
+
+	for _, c := range s.enabled {
+		c.WrapEvent(msg)
+	}
+
+	return msg
+}
+
+// Negotiator drives the CAP LS/REQ/ACK handshake for a fixed set of
+// desired capabilities.
+type Negotiator struct {
+	wanted map[string]Capability
+	set    *Set
+}
+
+// NewNegotiator builds a Negotiator that will request whichever of caps
+// the server advertises support for.
+func NewNegotiator(caps ...Capability) *Negotiator {
+	wanted := make(map[string]Capability, len(caps))
+	for _, c := range caps {
+		wanted[c.Name()] = c
+	}
+
+	return &Negotiator{wanted: wanted, set: newSet()}
+}
+
+// Set returns the negotiated capability set. It's only populated once
+// registration (CAP END) has completed.
+func (n *Negotiator) Set() *Set {
+	return n.set
+}
+
+// Register wires the handshake's callbacks into c. It must be called
+// before c.Connect, not after: go-ircevent's Connect starts the read
+// loop before it sends NICK/USER and returns, so a callback added only
+// once Connect returns can lose the race against an early reply (a
+// fast or lenient server — some bouncer configurations, for instance —
+// may reply before the caller gets back around to registering
+// anything). Registering first means the handlers are always in place
+// before a single byte is processed.
+//
+// Register alone doesn't send anything; call Negotiate once c is
+// connected to actually kick off the handshake.
+func (n *Negotiator) Register(c *irc.Connection) {
+	var ls []string
+
+	c.AddCallback("CAP", func(event *irc.Event) {
+		if len(event.Arguments) < 3 {
+			return
+		}
+
+		switch event.Arguments[1] {
+		case "LS":
+			more, tokens := splitLS(event.Arguments[2:])
+			ls = append(ls, strings.Fields(tokens)...)
+
+			if !more {
+				n.request(c, ls)
+				ls = nil
+			}
+		case "ACK":
+			n.ack(c, event.Arguments[len(event.Arguments)-1])
+		case "NAK":
+			c.SendRawf("CAP END")
+		}
+	})
+
+	c.AddCallback("001", func(event *irc.Event) {
+		for _, enabled := range n.set.enabled {
+			enabled.OnRegister(c)
+		}
+	})
+}
+
+// Negotiate sends CAP LS to start the handshake. c must already be
+// connected: sending anything earlier has no write loop to land on.
+//
+// This is later on the wire than the IRCv3 spec's "negotiate before
+// NICK/USER" expectation — go-ircevent's Connect queues both before it
+// returns, and doesn't expose a hook earlier than that for capabilities
+// outside its own built-in SASL handling. In practice a server holds
+// off 001 until CAP END regardless, so this is fine against any
+// spec-compliant server; it hasn't been verified against a lenient
+// bouncer that might complete registration on USER without waiting.
+func (n *Negotiator) Negotiate(c *irc.Connection) {
+	c.SendRawf("CAP LS 302")
+}
+
+// splitLS splits the tail of a CAP LS argument list (everything after
+// the nick and "LS") into whether more lines follow — the "*"
+// continuation marker used by multiline CAP LS 302 — and this line's
+// space-separated capability tokens.
+func splitLS(args []string) (more bool, tokens string) {
+	if len(args) > 1 && args[0] == "*" {
+		return true, args[1]
+	}
+
+	return false, args[len(args)-1]
+}
+
+func (n *Negotiator) request(c *irc.Connection, names []string) {
+	var req []string
+
+	for _, name := range names {
+		name = strings.SplitN(name, "=", 2)[0]
+		if _, ok := n.wanted[name]; ok {
+			req = append(req, name)
+		}
+	}
+
+	if len(req) == 0 {
+		c.SendRawf("CAP END")
+		return
+	}
+
+	c.SendRawf("CAP REQ :%s", strings.Join(req, " "))
+}
+
+func (n *Negotiator) ack(c *irc.Connection, list string) {
+	for _, name := range strings.Fields(list) {
+		if wanted, ok := n.wanted[name]; ok {
+			n.set.enabled[name] = wanted
+		}
+	}
+
+	c.SendRawf("CAP END")
+}
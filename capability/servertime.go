@@ -0,0 +1,28 @@
+package capability
+
+import (
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+// ServerTime implements the server-time capability: it parses the
+// "time" message tag and uses it as a Message's display timestamp,
+// so scrollback replayed from a bouncer shows the original times
+// rather than the moment nako received it.
+type ServerTime struct{}
+
+func (ServerTime) Name() string { return "server-time" }
+
+func (ServerTime) OnRegister(c *irc.Connection) {}
+
+func (ServerTime) WrapEvent(msg *Message) {
+	raw, ok := msg.Event.Tags["time"]
+	if !ok {
+		return
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		msg.Time = t
+	}
+}
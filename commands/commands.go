@@ -0,0 +1,150 @@
+// Package commands implements nako's slash-command system: a registry of
+// built-in commands that genSendMsg dispatches to instead of PRIVMSG'ing
+// the raw input.
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/awesome-gocui/gocui"
+	irc "github.com/thoj/go-ircevent"
+)
+
+// Command is a single slash command: its handler and help text.
+type Command struct {
+	Run         func(c *irc.Connection, g *gocui.Gui, args []string) error
+	Description string
+}
+
+// Registry holds every built-in command, keyed by verb (without the
+// leading "/").
+var Registry = map[string]*Command{}
+
+// ActiveChannel reports the currently selected buffer's channel. main
+// wires this up to the bufferSet so commands that default to "the
+// current channel" (/me, /part) don't need their own connection to it.
+var ActiveChannel func() string
+
+// AddBuffer and RemoveBuffer let /join and /part grow and prune the
+// bufferSet, which otherwise has no way to learn about channels joined
+// or left after startup. main wires both up to the bufferSet.
+var (
+	AddBuffer    func(channel string)
+	RemoveBuffer func(channel string)
+)
+
+func register(verb string, cmd *Command) {
+	Registry[verb] = cmd
+}
+
+func init() {
+	register("help", &Command{Run: runHelp, Description: "/help - list available commands"})
+	register("join", &Command{Run: runJoin, Description: "/join <channel> - join a channel"})
+	register("part", &Command{Run: runPart, Description: "/part [channel] - leave a channel, defaulting to the active one"})
+	register("msg", &Command{Run: runMsg, Description: "/msg <nick> <text> - send a private message"})
+	register("me", &Command{Run: runMe, Description: "/me <action> - send a CTCP ACTION to the active channel"})
+	register("nick", &Command{Run: runNick, Description: "/nick <new> - change your nickname"})
+	register("quit", &Command{Run: runQuit, Description: "/quit [reason] - disconnect and exit"})
+	register("raw", &Command{Run: runRaw, Description: "/raw <line> - send a raw line to the server"})
+}
+
+func runHelp(c *irc.Connection, g *gocui.Gui, args []string) error {
+	verbs := make([]string, 0, len(Registry))
+	for verb := range Registry {
+		verbs = append(verbs, verb)
+	}
+	sort.Strings(verbs)
+
+	g.Update(func(g *gocui.Gui) error {
+		v, err := g.View("chat")
+		if err != nil {
+			return err
+		}
+
+		for _, verb := range verbs {
+			fmt.Fprintln(v, Registry[verb].Description)
+		}
+
+		return nil
+	})
+
+	return nil
+}
+
+func runJoin(c *irc.Connection, g *gocui.Gui, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: /join <channel>")
+	}
+
+	channel := args[0]
+	c.Join(channel)
+	AddBuffer(channel)
+
+	return nil
+}
+
+func runPart(c *irc.Connection, g *gocui.Gui, args []string) error {
+	channel := ActiveChannel()
+	if len(args) > 0 {
+		channel = args[0]
+	}
+
+	c.Part(channel)
+	RemoveBuffer(channel)
+
+	return nil
+}
+
+func runMsg(c *irc.Connection, g *gocui.Gui, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: /msg <nick> <text>")
+	}
+
+	c.Privmsg(args[0], strings.Join(args[1:], " "))
+
+	return nil
+}
+
+func runMe(c *irc.Connection, g *gocui.Gui, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: /me <action>")
+	}
+
+	c.Action(ActiveChannel(), strings.Join(args, " "))
+
+	return nil
+}
+
+func runNick(c *irc.Connection, g *gocui.Gui, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: /nick <new>")
+	}
+
+	c.Nick(args[0])
+
+	return nil
+}
+
+func runQuit(c *irc.Connection, g *gocui.Gui, args []string) error {
+	reason := "leaving"
+	if len(args) > 0 {
+		reason = strings.Join(args, " ")
+	}
+
+	c.QuitMessage = reason
+	c.Quit()
+
+	return gocui.ErrQuit
+}
+
+func runRaw(c *irc.Connection, g *gocui.Gui, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: /raw <line>")
+	}
+
+	c.SendRaw(strings.Join(args, " "))
+
+	return nil
+}
@@ -0,0 +1,62 @@
+// Package highlight detects mentions of the user in incoming messages
+// and fires pluggable notifiers when one is found.
+package highlight
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Matcher tests messages for the user's nick or any configured extra
+// pattern, each as a case-insensitive, word-bounded match.
+type Matcher struct {
+	patterns []*regexp.Regexp
+}
+
+// NewMatcher builds a Matcher for nick plus any additional patterns
+// (e.g. from --highlight/NAKO_HIGHLIGHT).
+func NewMatcher(nick string, extra []string) *Matcher {
+	patterns := make([]*regexp.Regexp, 0, len(extra)+1)
+	patterns = append(patterns, wordPattern(nick))
+
+	for _, p := range extra {
+		if p == "" {
+			continue
+		}
+
+		patterns = append(patterns, wordPattern(p))
+	}
+
+	return &Matcher{patterns: patterns}
+}
+
+func wordPattern(s string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(s))
+}
+
+// Match reports whether msg mentions the user: one of the patterns
+// appears in msg with no letter, digit or underscore directly touching either
+// side. This deliberately isn't regexp's \b: IRCv3 nicks are legally
+// built from punctuation like "[", "|" or "`", and \b only recognizes a
+// boundary where word-class and non-word-class runes meet — so a nick
+// like "[alice]", surrounded by spaces, never gets a \b at either end
+// and silently fails to match at all.
+func (m *Matcher) Match(msg string) bool {
+	for _, p := range m.patterns {
+		for _, loc := range p.FindAllStringIndex(msg, -1) {
+			before, _ := utf8.DecodeLastRuneInString(msg[:loc[0]])
+			after, _ := utf8.DecodeRuneInString(msg[loc[1]:])
+
+			if !isWordRune(before) && !isWordRune(after) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
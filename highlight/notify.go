@@ -0,0 +1,135 @@
+package highlight
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Notifier reacts to a detected mention.
+type Notifier interface {
+	Notify(channel, nick, message string, t time.Time) error
+}
+
+// Group fires every notifier in turn, ignoring individual failures so
+// one broken notifier (e.g. an unreachable webhook) can't take down
+// the others.
+type Group []Notifier
+
+func (g Group) Notify(channel, nick, message string, t time.Time) {
+	for _, n := range g {
+		n.Notify(channel, nick, message, t)
+	}
+}
+
+// ParseNotifiers parses a --notify/NAKO_NOTIFY spec such as
+// "bell,desktop,webhook:https://example.com/hook" into a Group.
+func ParseNotifiers(spec string) (Group, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var group Group
+
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+
+		switch {
+		case token == "":
+			continue
+		case token == "bell":
+			group = append(group, Bell{})
+		case token == "desktop":
+			group = append(group, Desktop{})
+		case strings.HasPrefix(token, "webhook:"):
+			url := strings.TrimPrefix(token, "webhook:")
+			if url == "" {
+				return nil, fmt.Errorf("webhook notifier needs a URL, e.g. webhook:https://example.com/hook")
+			}
+
+			group = append(group, Webhook{URL: url})
+		default:
+			return nil, fmt.Errorf("unknown notifier %q", token)
+		}
+	}
+
+	return group, nil
+}
+
+// Bell rings the terminal bell.
+type Bell struct{}
+
+func (Bell) Notify(channel, nick, message string, t time.Time) error {
+	_, err := os.Stdout.WriteString("\a")
+	return err
+}
+
+// Desktop fires a native desktop notification via notify-send (Linux)
+// or osascript (macOS), whichever is available.
+type Desktop struct{}
+
+func (Desktop) Notify(channel, nick, message string, t time.Time) error {
+	title := fmt.Sprintf("%s in %s", nick, channel)
+
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		return exec.Command(path, title, message).Run()
+	}
+
+	if path, err := exec.LookPath("osascript"); err == nil {
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(message), appleScriptQuote(title))
+		return exec.Command(path, "-e", script).Run()
+	}
+
+	return fmt.Errorf("no desktop notifier available (need notify-send or osascript)")
+}
+
+func appleScriptQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+
+	return `"` + s + `"`
+}
+
+// webhookTimeout bounds how long a webhook notifier may block. Notify
+// runs off the IRC connection's read goroutine (genMsgHandler in main.go
+// starts it with go), but
+// there's no reason to let a slow or unreachable endpoint hang forever.
+const webhookTimeout = 5 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Webhook POSTs a JSON payload describing the mention to URL.
+type Webhook struct {
+	URL string
+}
+
+type webhookPayload struct {
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func (w Webhook) Notify(channel, nick, message string, t time.Time) error {
+	body, err := json.Marshal(webhookPayload{Channel: channel, Nick: nick, Message: message, Time: t})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -14,6 +16,11 @@ import (
 
 	"github.com/awesome-gocui/gocui"
 	"github.com/logrusorgru/aurora"
+
+	"github.com/surskitt/nako/capability"
+	"github.com/surskitt/nako/commands"
+	"github.com/surskitt/nako/highlight"
+	"github.com/surskitt/nako/scrollback"
 )
 
 type Options struct {
@@ -27,89 +34,218 @@ type Options struct {
 	GlobalVerbose bool     `short:"V" long:"global-verbose" env:"NAKO_GLOBAL_VERBOSE" description:"Verbose logging across server"`
 	Debug         bool     `short:"d" long:"debug" env:"NAKO_DEBUG" description:"Debug logging"`
 	ShowJoins     bool     `short:"j" long:"show-joins" env:"NAKO_SHOW_JOINS" description:"Show join and part messages"`
+	LogDir        string   `long:"log-dir" env:"NAKO_LOG_DIR" default:"~/.local/state/nako" description:"Directory to persist per-channel scrollback logs"`
+	LogLines      int      `long:"log-lines" env:"NAKO_LOG_LINES" default:"200" description:"Scrollback lines to replay per channel on startup"`
+	Highlight     []string `long:"highlight" env:"NAKO_HIGHLIGHT" env-delim:"," description:"Additional words/patterns to treat as mentions"`
+	Notify        string   `long:"notify" env:"NAKO_NOTIFY" description:"Comma-separated notifiers to fire on mention: bell,desktop,webhook:<url>"`
 }
 
-func getTime() string {
-	t := time.Now()
-	ft := t.Format("15:04")
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
 
-	return aurora.Bold(ft).String()
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
 }
 
-func showMsg(nick, msg string, g *gocui.Gui) {
-	g.Update(func(g *gocui.Gui) error {
-		v, err := g.View("chat")
-		if err != nil {
-			return err
-		}
+func formatLine(t time.Time, msg string) string {
+	ft := aurora.Bold(t.Format("15:04")).String()
+
+	return fmt.Sprintf("%s %s", ft, msg)
+}
+
+func showMsgAt(bufs *bufferSet, name string, t time.Time, msg string, g *gocui.Gui) string {
+	line := formatLine(t, msg)
+	bufs.append(name, line)
+
+	if bufs.current() == name {
+		g.Update(func(g *gocui.Gui) error {
+			v, err := g.View("chat")
+			if err != nil {
+				return err
+			}
 
-		fmt.Fprintln(v, getTime(), msg)
+			fmt.Fprintln(v, line)
 
+			return nil
+		})
+	}
+
+	return line
+}
+
+// wake forces a redraw from outside gocui's own goroutine, e.g. from an
+// IRC callback running on the connection's read loop. genLayout
+// recomputes the channel view's label from bufs on every pass, so an
+// empty update is enough to pick up a freshly set mention badge.
+func wake(g *gocui.Gui) {
+	g.Update(func(g *gocui.Gui) error {
 		return nil
 	})
 }
 
-func showPrivMsg(nick, msg string, g *gocui.Gui) {
+func showMsg(bufs *bufferSet, name, msg string, g *gocui.Gui) string {
+	return showMsgAt(bufs, name, time.Now(), msg, g)
+}
+
+func showPrivMsgAt(bufs *bufferSet, channel string, t time.Time, nick, msg string, g *gocui.Gui) string {
 	out := fmt.Sprintf("%s: %s", nick, msg)
-	showMsg(nick, out, g)
+	return showMsgAt(bufs, channel, t, out, g)
 }
 
-func showJoinMsg(nick, channel string, g *gocui.Gui) {
+func showPrivMsg(bufs *bufferSet, channel, nick, msg string, g *gocui.Gui) string {
+	return showPrivMsgAt(bufs, channel, time.Now(), nick, msg, g)
+}
+
+func showJoinMsg(bufs *bufferSet, channel, nick string, g *gocui.Gui) string {
 	out := fmt.Sprintf("-> %s joined %s", nick, channel)
-	showMsg(nick, out, g)
+	return showMsg(bufs, channel, out, g)
 }
 
-func showPartMsg(nick, channel string, g *gocui.Gui) {
+func showPartMsg(bufs *bufferSet, channel, nick string, g *gocui.Gui) string {
 	out := fmt.Sprintf("<- %s left %s", nick, channel)
-	showMsg(nick, out, g)
+	return showMsg(bufs, channel, out, g)
 }
 
-func genMsgHandler(channel string, g *gocui.Gui) func(event *irc.Event) {
+func genMsgHandler(bufs *bufferSet, caps *capability.Set, store *scrollback.Store, hl *highlight.Matcher, notifiers highlight.Group, server string, g *gocui.Gui) func(event *irc.Event) {
 	return func(event *irc.Event) {
-		if event.Arguments[0] == channel {
-			nick := event.Nick
-			if nick == "" {
-				nick = event.Source
+		nick := event.Nick
+		if nick == "" {
+			nick = event.Source
+		}
+
+		target := event.Arguments[0]
+		if !bufs.has(target) {
+			target = statusBuffer
+		}
+
+		msg := caps.Wrap(event)
+		if store.Duplicate(server, target, msg.Time) {
+			return
+		}
+
+		text := event.Arguments[1]
+		display := text
+
+		mentioned := hl.Match(text)
+		if mentioned {
+			display = aurora.Red(text).Bold().String()
+		}
+
+		line := showPrivMsgAt(bufs, target, msg.Time, nick, display, g)
+		store.Append(server, target, msg.Time, line)
+
+		if mentioned {
+			if bufs.markMentioned(target) {
+				wake(g)
 			}
+			// genMsgHandler runs inline on the connection's read loop, so
+			// notifiers (which may block on a slow webhook) must not run
+			// synchronously here.
+			go notifiers.Notify(target, nick, text, msg.Time)
+		}
+	}
+}
+
+func genNoticeHandler(bufs *bufferSet, caps *capability.Set, store *scrollback.Store, server string, g *gocui.Gui) func(event *irc.Event) {
+	return func(event *irc.Event) {
+		nick := event.Nick
+		if nick == "" {
+			nick = event.Source
+		}
+
+		target := event.Arguments[0]
+		if !bufs.has(target) {
+			target = statusBuffer
+		}
+
+		msg := caps.Wrap(event)
+		if store.Duplicate(server, target, msg.Time) {
+			return
+		}
+
+		line := showMsgAt(bufs, target, msg.Time, fmt.Sprintf("-%s- %s", nick, event.Arguments[1]), g)
+		store.Append(server, target, msg.Time, line)
+	}
+}
 
-			showPrivMsg(nick, event.Arguments[1], g)
+func isNumeric(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
 		}
 	}
+
+	return true
+}
+
+func genStatusHandler(bufs *bufferSet, g *gocui.Gui) func(event *irc.Event) {
+	return func(event *irc.Event) {
+		if !isNumeric(event.Code) || len(event.Arguments) == 0 {
+			return
+		}
+
+		showMsg(bufs, statusBuffer, strings.Join(event.Arguments[1:], " "), g)
+	}
 }
 
-func genJoinHandler(channel string, g *gocui.Gui) func(event *irc.Event) {
+func genJoinHandler(bufs *bufferSet, store *scrollback.Store, server string, g *gocui.Gui) func(event *irc.Event) {
 	return func(event *irc.Event) {
 		go func(event *irc.Event) {
-			if event.Arguments[0] == channel {
-				switch event.Code {
-				case "JOIN":
-					showJoinMsg(event.Nick, event.Arguments[0], g)
-				case "QUIT":
-					showPartMsg(event.Nick, event.Arguments[0], g)
-				}
+			channel := event.Arguments[0]
+			if !bufs.has(channel) {
+				return
 			}
+
+			var line string
+
+			switch event.Code {
+			case "JOIN":
+				line = showJoinMsg(bufs, channel, event.Nick, g)
+			case "PART":
+				line = showPartMsg(bufs, channel, event.Nick, g)
+			default:
+				return
+			}
+
+			store.Append(server, channel, time.Now(), line)
 		}(event)
 	}
 }
 
-func genDebugHandler(channel string, global bool, g *gocui.Gui) func(event *irc.Event) {
+func genDebugHandler(bufs *bufferSet, global bool, g *gocui.Gui) func(event *irc.Event) {
 	return func(event *irc.Event) {
-		if event.Arguments[0] == channel || global {
-			showMsg("", fmt.Sprintf("Code: %s", event.Code), g)
-			showMsg("", fmt.Sprintf("Raw: %s", event.Raw), g)
-			showMsg("", fmt.Sprintf("Nick: %s", event.Nick), g)
-			showMsg("", fmt.Sprintf("Host: %s", event.Host), g)
-			showMsg("", fmt.Sprintf("Source: %s", event.Source), g)
-			showMsg("", fmt.Sprintf("User: %s", event.User), g)
-			showMsg("", fmt.Sprintf("Tags: %s", event.Tags), g)
-			showMsg("", fmt.Sprintf("Arguments: %s", event.Arguments), g)
+		target := statusBuffer
+		if len(event.Arguments) > 0 && bufs.has(event.Arguments[0]) {
+			target = event.Arguments[0]
+		} else if !global {
+			return
 		}
+
+		showMsg(bufs, target, fmt.Sprintf("Code: %s", event.Code), g)
+		showMsg(bufs, target, fmt.Sprintf("Raw: %s", event.Raw), g)
+		showMsg(bufs, target, fmt.Sprintf("Nick: %s", event.Nick), g)
+		showMsg(bufs, target, fmt.Sprintf("Host: %s", event.Host), g)
+		showMsg(bufs, target, fmt.Sprintf("Source: %s", event.Source), g)
+		showMsg(bufs, target, fmt.Sprintf("User: %s", event.User), g)
+		showMsg(bufs, target, fmt.Sprintf("Tags: %s", event.Tags), g)
+		showMsg(bufs, target, fmt.Sprintf("Arguments: %s", event.Arguments), g)
 	}
 }
 
-func genLayout(channel string) func(g *gocui.Gui) error {
+func genLayout(bufs *bufferSet) func(g *gocui.Gui) error {
 	return func(g *gocui.Gui) error {
 		maxX, maxY := g.Size()
+		label := bufs.label()
 
 		if v, err := g.SetView("chat", 0, 0, maxX, maxY-2, gocui.TOP); err != nil {
 			if !errors.Is(err, gocui.ErrUnknownView) {
@@ -121,18 +257,20 @@ func genLayout(channel string) func(g *gocui.Gui) error {
 			v.Frame = false
 		}
 
-		if v, err := g.SetView("channel", 0, maxY-2, len(channel)+2, maxY, gocui.TOP); err != nil {
+		cv, err := g.SetView("channel", 0, maxY-2, len(label)+2, maxY, gocui.TOP)
+		if err != nil {
 			if !errors.Is(err, gocui.ErrUnknownView) {
 				return err
 			}
 
-			v.Frame = false
-			v.FgColor = gocui.ColorGreen
-
-			fmt.Fprint(v, channel+">")
+			cv.Frame = false
+			cv.FgColor = gocui.ColorGreen
 		}
 
-		if v, err := g.SetView("entry", len(channel)+2, maxY-2, maxX, maxY, gocui.TOP); err != nil {
+		cv.Clear()
+		fmt.Fprint(cv, label+">")
+
+		if v, err := g.SetView("entry", len(label)+2, maxY-2, maxX, maxY, gocui.TOP); err != nil {
 			if !errors.Is(err, gocui.ErrUnknownView) {
 				return err
 			}
@@ -176,26 +314,90 @@ func chatSwitch(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
-func genSendMsg(c *irc.Connection, nick, channel string) func(g *gocui.Gui, v *gocui.View) error {
+// refreshChat redraws the chat view from the currently active buffer.
+// Call it whenever the active buffer changes so the switch is visible
+// immediately rather than waiting for the next unrelated redraw.
+func refreshChat(g *gocui.Gui, bufs *bufferSet) error {
+	chat, err := g.View("chat")
+	if err != nil {
+		return err
+	}
+
+	chat.Clear()
+	fmt.Fprint(chat, bufs.render(bufs.current()))
+
+	return nil
+}
+
+func genBufferSwitch(bufs *bufferSet, delta int) func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		bufs.switchTo(delta)
+
+		return refreshChat(g, bufs)
+	}
+}
+
+// genAddBuffer and genRemoveBuffer back commands.AddBuffer/RemoveBuffer:
+// /join and /part run on the same goroutine as other keybindings, so
+// they can redraw the chat view directly rather than via g.Update.
+func genAddBuffer(bufs *bufferSet, g *gocui.Gui) func(channel string) {
+	return func(channel string) {
+		bufs.add(channel)
+		refreshChat(g, bufs)
+	}
+}
+
+func genRemoveBuffer(bufs *bufferSet, g *gocui.Gui) func(channel string) {
+	return func(channel string) {
+		bufs.remove(channel)
+		refreshChat(g, bufs)
+	}
+}
+
+func dispatchCommand(c *irc.Connection, bufs *bufferSet, g *gocui.Gui, input string) error {
+	fields := strings.Fields(input)
+	verb := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+	args := fields[1:]
+
+	cmd, ok := commands.Registry[verb]
+	if !ok {
+		showMsg(bufs, bufs.current(), fmt.Sprintf("Unknown command: /%s", verb), g)
+		return nil
+	}
+
+	if err := cmd.Run(c, g, args); err != nil {
+		if errors.Is(err, gocui.ErrQuit) {
+			return err
+		}
+
+		showMsg(bufs, bufs.current(), fmt.Sprintf("/%s: %s", verb, err), g)
+	}
+
+	return nil
+}
+
+func genSendMsg(c *irc.Connection, bufs *bufferSet, store *scrollback.Store, server, nick string) func(g *gocui.Gui, v *gocui.View) error {
 	return func(g *gocui.Gui, v *gocui.View) error {
 		if v.Buffer() == "" {
 			return nil
 		}
 
-		msg := v.Buffer() + " "
-		c.Privmsg(channel, msg)
+		input := strings.TrimRight(v.Buffer(), "\n")
 		v.Clear()
 
-		g.Update(func(g *gocui.Gui) error {
-			v, err := g.View("chat")
-			if err != nil {
-				return err
-			}
-
-			fmt.Fprintln(v, fmt.Sprintf("%s %s: %s", getTime(), nick, msg))
+		if strings.HasPrefix(input, "/") {
+			return dispatchCommand(c, bufs, g, input)
+		}
 
+		channel := bufs.current()
+		if channel == statusBuffer {
 			return nil
-		})
+		}
+
+		msg := input + " "
+		c.Privmsg(channel, msg)
+		line := showPrivMsg(bufs, channel, nick, msg, g)
+		store.Append(server, channel, time.Now(), line)
 
 		return nil
 	}
@@ -223,7 +425,19 @@ func main() {
 	g.SelFgColor = gocui.ColorGreen
 	g.SelFrameColor = gocui.ColorGreen
 
-	g.SetManagerFunc(genLayout(opts.Channels[0]))
+	bufs := newBufferSet(opts.Channels)
+	commands.ActiveChannel = bufs.current
+	commands.AddBuffer = genAddBuffer(bufs, g)
+	commands.RemoveBuffer = genRemoveBuffer(bufs, g)
+
+	store := scrollback.New(expandHome(opts.LogDir), opts.LogLines)
+	for _, channel := range opts.Channels {
+		for _, line := range store.Replay(opts.Server, channel) {
+			bufs.append(channel, line)
+		}
+	}
+
+	g.SetManagerFunc(genLayout(bufs))
 
 	irccon := irc.IRC(opts.Nick, opts.User)
 	irccon.Debug = opts.Debug
@@ -238,17 +452,31 @@ func main() {
 
 	irccon.Password = opts.Password
 
-	irccon.AddCallback("PRIVMSG", genMsgHandler(opts.Channels[0], g))
+	negotiator := capability.NewNegotiator(capability.ServerTime{})
+	caps := negotiator.Set()
+
+	hl := highlight.NewMatcher(opts.Nick, opts.Highlight)
+
+	notifiers, err := highlight.ParseNotifiers(opts.Notify)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	irccon.AddCallback("PRIVMSG", genMsgHandler(bufs, caps, store, hl, notifiers, opts.Server, g))
+	irccon.AddCallback("NOTICE", genNoticeHandler(bufs, caps, store, opts.Server, g))
+	irccon.AddCallback("*", genStatusHandler(bufs, g))
 
 	if opts.ShowJoins {
-		irccon.AddCallback("JOIN", genJoinHandler(opts.Channels[0], g))
-		irccon.AddCallback("PART", genJoinHandler(opts.Channels[0], g))
+		irccon.AddCallback("JOIN", genJoinHandler(bufs, store, opts.Server, g))
+		irccon.AddCallback("PART", genJoinHandler(bufs, store, opts.Server, g))
 	}
 
 	if opts.Verbose || opts.GlobalVerbose {
-		irccon.AddCallback("*", genDebugHandler(opts.Channels[0], opts.GlobalVerbose, g))
+		irccon.AddCallback("*", genDebugHandler(bufs, opts.GlobalVerbose, g))
 	}
 
+	negotiator.Register(irccon)
+
 	retrier := retry.NewRetrier(5, 100*time.Millisecond, 5*time.Second)
 	err = retrier.Run(func() error {
 		return irccon.Connect(opts.Server)
@@ -257,8 +485,12 @@ func main() {
 		log.Panicln(err)
 	}
 
+	negotiator.Negotiate(irccon)
+
 	irccon.AddCallback("001", func(e *irc.Event) {
-		irccon.Join(opts.Channels[0])
+		for _, channel := range opts.Channels {
+			irccon.Join(channel)
+		}
 	})
 
 	go irccon.Loop()
@@ -275,7 +507,23 @@ func main() {
 		log.Panicln(err)
 	}
 
-	if err := g.SetKeybinding("entry", gocui.KeyEnter, gocui.ModNone, genSendMsg(irccon, opts.Nick, opts.Channels[0])); err != nil {
+	if err := g.SetKeybinding("entry", gocui.KeyCtrlN, gocui.ModNone, genBufferSwitch(bufs, 1)); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := g.SetKeybinding("chat", gocui.KeyCtrlN, gocui.ModNone, genBufferSwitch(bufs, 1)); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := g.SetKeybinding("entry", gocui.KeyCtrlP, gocui.ModNone, genBufferSwitch(bufs, -1)); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := g.SetKeybinding("chat", gocui.KeyCtrlP, gocui.ModNone, genBufferSwitch(bufs, -1)); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := g.SetKeybinding("entry", gocui.KeyEnter, gocui.ModNone, genSendMsg(irccon, bufs, store, opts.Server, opts.Nick)); err != nil {
 		log.Panicln(err)
 	}
 
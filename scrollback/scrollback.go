@@ -0,0 +1,154 @@
+// Package scrollback persists per-channel chat history to disk and
+// replays it back into a channel's buffer on startup.
+package scrollback
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogBytes is the size a channel's log file is allowed to reach
+// before it's rotated aside.
+const maxLogBytes = 1 << 20 // 1MiB
+
+// Store persists per-channel scrollback under dir/<server>/<channel>.log.
+type Store struct {
+	dir   string
+	lines int
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// New returns a Store rooted at dir, replaying up to lines of history
+// per channel.
+func New(dir string, lines int) *Store {
+	return &Store{
+		dir:   dir,
+		lines: lines,
+		last:  make(map[string]time.Time),
+	}
+}
+
+func (s *Store) path(server, channel string) string {
+	return filepath.Join(s.dir, server, channel+".log")
+}
+
+func key(server, channel string) string {
+	return server + "/" + channel
+}
+
+// Replay reads up to s.lines of the most recent scrollback for channel
+// and returns it rendered, oldest first. It also records the timestamp
+// of the newest line so a later Append can recognise a bouncer
+// replaying history we already have instead of double-logging it.
+func (s *Store) Replay(server, channel string) []string {
+	f, err := os.Open(s.path(server, channel))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries []logEntry
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry, ok := parseLogLine(scanner.Text()); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) > s.lines {
+		entries = entries[len(entries)-s.lines:]
+	}
+
+	if len(entries) > 0 {
+		s.mu.Lock()
+		s.last[key(server, channel)] = entries[len(entries)-1].time
+		s.mu.Unlock()
+	}
+
+	rendered := make([]string, len(entries))
+	for i, entry := range entries {
+		rendered[i] = entry.rendered
+	}
+
+	return rendered
+}
+
+// Duplicate reports whether t is no newer than the last message
+// recorded for channel — i.e. a bouncer replaying history already
+// stored rather than a genuinely new message. Callers that display
+// messages check this before rendering them, not only before logging
+// them via Append, so a replay doesn't show up twice: once from Replay
+// at startup and again live from the bouncer.
+func (s *Store) Duplicate(server, channel string, t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !t.After(s.last[key(server, channel)])
+}
+
+// Append records line, timestamped t, to channel's log — unless t is no
+// newer than the last line already seen for that channel, which means
+// it's a bouncer replaying history we've already stored rather than a
+// new message.
+func (s *Store) Append(server, channel string, t time.Time, line string) {
+	k := key(server, channel)
+
+	s.mu.Lock()
+	if !t.After(s.last[k]) {
+		s.mu.Unlock()
+		return
+	}
+	s.last[k] = t
+	s.mu.Unlock()
+
+	path := s.path(server, channel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+
+	s.rotate(path)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "%s\t%s\n", t.Format(time.RFC3339Nano), line)
+}
+
+func (s *Store) rotate(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < maxLogBytes {
+		return
+	}
+
+	os.Rename(path, path+".1")
+}
+
+type logEntry struct {
+	time     time.Time
+	rendered string
+}
+
+func parseLogLine(line string) (logEntry, bool) {
+	ts, rendered, ok := strings.Cut(line, "\t")
+	if !ok {
+		return logEntry{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return logEntry{}, false
+	}
+
+	return logEntry{time: t, rendered: rendered}, true
+}